@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+
+	hostlist "github.com/abrekhov/hostlist"
+)
+
+// runClient implements the short-lived CLI invoked directly by Slurm's
+// ResumeProgram/SuspendProgram. It expands the hostlist it was given,
+// forwards the request(s) to slurm-autoscale-tfed over a Unix socket, and
+// blocks until the daemon acknowledges every update. With configPath set it
+// routes nodes across the pools declared there instead of the single
+// env-var-configured pool.
+func runClient(action, hostlistArg, configPath string) {
+	if configPath != "" {
+		runClientMultiPool(action, hostlistArg, configPath)
+		return
+	}
+
+	req := wireRequest{
+		Organization:  os.Getenv("TFE_ORGANIZATION"),
+		VarSetID:      os.Getenv("TFE_VARSET_ID"),
+		VarSetName:    os.Getenv("TFE_VARSET_NAME"),
+		WorkspaceIDs:  workspaceIDsFromEnv(),
+		WorkspaceName: os.Getenv("TFE_WORKSPACE_NAME"),
+		Action:        action,
+		Nodes:         hostlist.ExpandNodeList(hostlistArg),
+		Hostlist:      hostlistArg,
+	}
+	if req.VarSetID == "" && req.VarSetName == "" && len(req.WorkspaceIDs) == 0 && req.WorkspaceName == "" {
+		log.Fatal("set TFE_WORKSPACE_ID, TFE_WORKSPACE_IDS, TFE_WORKSPACE_NAME, TFE_VARSET_ID or TFE_VARSET_NAME")
+	}
+
+	resp, err := sendRequest(req)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if resp.Error != "" {
+		log.Fatal(resp.Error)
+	}
+	if resp.RunID != "" {
+		log.Printf("run %s finished with status %s", resp.RunID, resp.RunStatus)
+	}
+}
+
+// runClientMultiPool expands the hostlist once, routes each node to the
+// pool whose pattern matches it, and issues one request per affected pool.
+func runClientMultiPool(action, hostlistArg, configPath string) {
+	cfg, err := loadPoolConfig(configPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	nodes := hostlist.ExpandNodeList(hostlistArg)
+	routed, err := cfg.route(nodes)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	for i, poolNodes := range routed {
+		p := cfg.Pools[i]
+		req := wireRequest{
+			Organization:  p.Organization,
+			VariableName:  p.VariableName,
+			VarSetID:      p.VarSetID,
+			WorkspaceName: p.WorkspaceName,
+			Action:        action,
+			Nodes:         poolNodes,
+			Hostlist:      strings.Join(poolNodes, ","),
+		}
+		if p.WorkspaceID != "" {
+			req.WorkspaceIDs = []string{p.WorkspaceID}
+		}
+
+		resp, err := sendRequest(req)
+		if err != nil {
+			log.Fatalf("pool %s: %v", p.VariableName, err)
+		}
+		if resp.Error != "" {
+			log.Fatalf("pool %s: %s", p.VariableName, resp.Error)
+		}
+		if resp.RunID != "" {
+			log.Printf("pool %s: run %s finished with status %s", p.VariableName, resp.RunID, resp.RunStatus)
+		}
+	}
+}
+
+// sendRequest dials slurm-autoscale-tfed, sends one wireRequest, and returns
+// its wireResponse.
+func sendRequest(req wireRequest) (wireResponse, error) {
+	conn, err := net.Dial("unix", socketPath())
+	if err != nil {
+		return wireResponse{}, fmt.Errorf("connecting to slurm-autoscale-tfed at %s: %w (is the daemon running?)", socketPath(), err)
+	}
+	defer conn.Close()
+
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return wireResponse{}, err
+	}
+
+	var resp wireResponse
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return wireResponse{}, err
+	}
+	return resp, nil
+}
+
+// workspaceIDsFromEnv reads TFE_WORKSPACE_IDS (a comma-separated list, for
+// propagating one mutation to several workspaces) and falls back to the
+// single TFE_WORKSPACE_ID used before variable sets existed.
+func workspaceIDsFromEnv() []string {
+	if list := os.Getenv("TFE_WORKSPACE_IDS"); list != "" {
+		var ids []string
+		for _, id := range strings.Split(list, ",") {
+			if id = strings.TrimSpace(id); id != "" {
+				ids = append(ids, id)
+			}
+		}
+		return ids
+	}
+	if id := os.Getenv("TFE_WORKSPACE_ID"); id != "" {
+		return []string{id}
+	}
+	return nil
+}
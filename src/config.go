@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+
+	hostlist "github.com/abrekhov/hostlist"
+	tfe "github.com/hashicorp/go-tfe"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// defaultConfigPath is the config file list-pools reads when -config is not
+// given. resume and suspend do not use it: their -config defaults to
+// SLURM_AUTOSCALE_TFE_CONFIG, and with neither set they use the single
+// env-var-configured pool.
+const defaultConfigPath = "/etc/slurm-autoscale-tfe.yaml"
+
+// poolEntry is one pool declared in the config file: which variable holds
+// its membership, where that variable lives, and the rule used to route
+// incoming nodes to it — either a regex Pattern or a Slurm Hostlist (e.g.
+// "gpu[001-032]"), exactly one of which must be set. This mirrors the shape
+// of GKE's per-node-pool configuration.
+type poolEntry struct {
+	VariableName  string `yaml:"variable_name"`
+	WorkspaceID   string `yaml:"workspace_id"`
+	WorkspaceName string `yaml:"workspace_name"`
+	Organization  string `yaml:"organization"`
+	VarSetID      string `yaml:"varset_id"`
+	Pattern       string `yaml:"pattern"`
+	Hostlist      string `yaml:"hostlist"`
+
+	re    *regexp.Regexp
+	nodes map[string]bool
+}
+
+// poolConfig is the top-level shape of --config's YAML file: an ordered
+// list of pools, matched first-to-last.
+type poolConfig struct {
+	Pools []poolEntry `yaml:"pools"`
+}
+
+func loadPoolConfig(path string) (*poolConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfg poolConfig
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	for i := range cfg.Pools {
+		p := &cfg.Pools[i]
+		if p.VariableName == "" {
+			p.VariableName = defaultVariableName
+		}
+		if p.WorkspaceID == "" && p.WorkspaceName == "" && p.VarSetID == "" {
+			return nil, fmt.Errorf("pool %q needs a workspace_id, a workspace_name or a varset_id", p.VariableName)
+		}
+		switch {
+		case p.Pattern != "" && p.Hostlist != "":
+			return nil, fmt.Errorf("pool %q: set pattern or hostlist, not both", p.VariableName)
+		case p.Hostlist != "":
+			p.nodes = make(map[string]bool)
+			for _, n := range hostlist.ExpandNodeList(p.Hostlist) {
+				p.nodes[n] = true
+			}
+		case p.Pattern != "":
+			p.re, err = regexp.Compile(p.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("pool %q: invalid pattern %q: %w", p.VariableName, p.Pattern, err)
+			}
+		default:
+			return nil, fmt.Errorf("pool %q needs a pattern or a hostlist", p.VariableName)
+		}
+	}
+	return &cfg, nil
+}
+
+// route assigns each node to the first pool whose pattern or hostlist
+// matches it, in config order, and returns the matched nodes keyed by pool
+// index.
+func (c *poolConfig) route(nodes []string) (map[int][]string, error) {
+	routed := make(map[int][]string)
+	for _, node := range nodes {
+		matched := false
+		for i, p := range c.Pools {
+			if p.nodes != nil {
+				if !p.nodes[node] {
+					continue
+				}
+			} else if !p.re.MatchString(node) {
+				continue
+			}
+			routed[i] = append(routed[i], node)
+			matched = true
+			break
+		}
+		if !matched {
+			return nil, fmt.Errorf("node %s does not match any pool pattern in the config", node)
+		}
+	}
+	return routed, nil
+}
+
+// runListPools prints the current membership of every pool in the config,
+// for operators debugging why a node did or didn't get routed somewhere.
+func runListPools(path string) {
+	cfg, err := loadPoolConfig(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	client, err := tfe.NewClient(tfe.DefaultConfig())
+	if err != nil {
+		log.Fatal(err)
+	}
+	ctx := context.Background()
+
+	for _, p := range cfg.Pools {
+		var pool_set map[string]bool
+		if p.VarSetID != "" {
+			_, pool_set, err = readVarSetPoolVariable(ctx, client, p.VarSetID, p.VariableName)
+		} else {
+			workspaceIDs, err2 := resolveWorkspaceIDs(ctx, client, p.Organization, nonEmpty(p.WorkspaceID), p.WorkspaceName)
+			if err2 != nil {
+				log.Printf("%s: %v", p.VariableName, err2)
+				continue
+			}
+			for _, workspaceID := range workspaceIDs {
+				var wsPool map[string]bool
+				_, wsPool, err = readPoolVariable(ctx, client, workspaceID, p.VariableName)
+				if err != nil {
+					break
+				}
+				if pool_set == nil {
+					pool_set = make(map[string]bool)
+				}
+				for node := range wsPool {
+					pool_set[node] = true
+				}
+			}
+		}
+		if err != nil {
+			log.Printf("%s: %v", p.VariableName, err)
+			continue
+		}
+
+		nodes := make([]string, 0, len(pool_set))
+		for n := range pool_set {
+			nodes = append(nodes, n)
+		}
+		sort.Strings(nodes)
+		rule := p.Pattern
+		if rule == "" {
+			rule = p.Hostlist
+		}
+		fmt.Printf("%s (%s): %v\n", p.VariableName, rule, nodes)
+	}
+}
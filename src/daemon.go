@@ -0,0 +1,317 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+
+	tfe "github.com/hashicorp/go-tfe"
+)
+
+// defaultSocketPath is where slurm-autoscale-tfed listens and where the
+// client dials by default. Override with SLURM_AUTOSCALE_TFE_SOCKET when
+// running more than one daemon on a host (e.g. one per Slurm cluster).
+const defaultSocketPath = "/var/run/slurm-autoscale-tfed.sock"
+
+// varUpdateRetries bounds how many read-modify-write attempts the daemon
+// makes in all, so it re-reads and re-applies its delta at most
+// varUpdateRetries-1 times after TFE reports a stale write (the variable's
+// ETag/version changed between our List and our Update).
+const varUpdateRetries = 5
+
+// defaultVariableName is the variable key used when a request doesn't name
+// one explicitly, preserving today's single flat "pool" variable.
+const defaultVariableName = "pool"
+
+func socketPath() string {
+	if p := os.Getenv("SLURM_AUTOSCALE_TFE_SOCKET"); p != "" {
+		return p
+	}
+	return defaultSocketPath
+}
+
+// wireRequest is what the short-lived CLI sends the daemon over the Unix
+// socket for a single resume/suspend invocation. The daemon resolves
+// VarSetName and WorkspaceName (if set) since it's the one holding the TFE
+// client; WorkspaceIDs/WorkspaceName end up naming more than one workspace
+// when TFE_WORKSPACE_IDS or a TFE_WORKSPACE_NAME glob fans the mutation out.
+type wireRequest struct {
+	Organization  string   `json:"organization,omitempty"`
+	VarSetID      string   `json:"varset_id,omitempty"`
+	VarSetName    string   `json:"varset_name,omitempty"`
+	WorkspaceIDs  []string `json:"workspace_ids"`
+	WorkspaceName string   `json:"workspace_name,omitempty"`
+	VariableName  string   `json:"variable_name,omitempty"`
+	Action        string   `json:"action"`
+	Nodes         []string `json:"nodes"`
+	Hostlist      string   `json:"hostlist"`
+}
+
+type wireResponse struct {
+	Error     string `json:"error,omitempty"`
+	RunID     string `json:"run_id,omitempty"`
+	RunStatus string `json:"run_status,omitempty"`
+}
+
+// runDaemon starts slurm-autoscale-tfed: it owns the TFE client, serializes
+// pool mutations through a taskPool, and batches everything that arrives
+// within a coalescing window into one write per target.
+func runDaemon() {
+	fs := flag.NewFlagSet("daemon", flag.ExitOnError)
+	runCfg := parseRunConfig(fs)
+	fs.Parse(os.Args[2:])
+
+	client, err := tfe.NewClient(tfe.DefaultConfig())
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	pool := newTaskPool(func(ctx *batchContext) taskResult {
+		return applyBatch(context.Background(), client, runCfg, ctx)
+	})
+	go pool.run()
+
+	path := socketPath()
+	os.Remove(path)
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer listener.Close()
+	log.Println("slurm-autoscale-tfed listening on", path)
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			log.Println("accept:", err)
+			continue
+		}
+		go handleConn(client, pool, conn)
+	}
+}
+
+func handleConn(client *tfe.Client, pool *taskPool, conn net.Conn) {
+	defer conn.Close()
+
+	var req wireRequest
+	if err := json.NewDecoder(conn).Decode(&req); err != nil {
+		json.NewEncoder(conn).Encode(wireResponse{Error: err.Error()})
+		return
+	}
+
+	ctx := context.Background()
+
+	varSetID, err := resolveVarSetID(ctx, client, req.Organization, req.VarSetID, req.VarSetName)
+	if err != nil {
+		json.NewEncoder(conn).Encode(wireResponse{Error: err.Error()})
+		return
+	}
+
+	var workspaceIDs []string
+	if varSetID == "" {
+		workspaceIDs, err = resolveWorkspaceIDs(ctx, client, req.Organization, req.WorkspaceIDs, req.WorkspaceName)
+		if err != nil {
+			json.NewEncoder(conn).Encode(wireResponse{Error: err.Error()})
+			return
+		}
+	}
+
+	target := varSetID
+	if target == "" {
+		target = strings.Join(workspaceIDs, ",")
+	}
+	if target == "" {
+		json.NewEncoder(conn).Encode(wireResponse{Error: "request carries neither a variable set nor a workspace"})
+		return
+	}
+
+	variableName := req.VariableName
+	if variableName == "" {
+		variableName = defaultVariableName
+	}
+
+	result := pool.submit(&task{
+		target:       target,
+		variableName: variableName,
+		varSetID:     varSetID,
+		workspaceIDs: workspaceIDs,
+		action:       req.Action,
+		nodes:        req.Nodes,
+		hostlist:     req.Hostlist,
+	})
+
+	resp := wireResponse{RunID: result.runID, RunStatus: result.runStatus}
+	if result.err != nil {
+		resp.Error = result.err.Error()
+	}
+	json.NewEncoder(conn).Encode(resp)
+}
+
+// applyBatch routes a flushed batch to the right write path: a variable set,
+// several workspaces at once, or a single workspace (today's default).
+func applyBatch(ctx context.Context, client *tfe.Client, runCfg *runConfig, b *batchContext) taskResult {
+	switch {
+	case b.varSetID != "":
+		return updateVarSetPool(ctx, client, runCfg, b.varSetID, b.variableName, b.mutations, b.action, b.hostlists)
+	case len(b.workspaceIDs) > 1:
+		return updateWorkspacesPool(ctx, client, runCfg, b.workspaceIDs, b.variableName, b.mutations, b.action, b.hostlists)
+	case len(b.workspaceIDs) == 1:
+		return updateWorkspacePool(ctx, client, runCfg, b.workspaceIDs[0], b.variableName, b.mutations, b.action, b.hostlists)
+	default:
+		return taskResult{err: fmt.Errorf("batch has no variable set or workspace to write to")}
+	}
+}
+
+// updateWorkspacePool applies mutations (node -> true for resume/add, false
+// for suspend/remove) to the workspace variable named variableName ("pool"
+// unless --config names another). If TFE reports the
+// variable changed underneath us (a stale write), it re-reads the current
+// value and re-applies the same delta rather than clobbering whatever the
+// other writer added. When runCfg.enabled and the pool actually changed, it
+// also queues a TFE run for the batch's nodes (targeted only when
+// runCfg.targetAddrFormat is set).
+func updateWorkspacePool(ctx context.Context, client *tfe.Client, runCfg *runConfig, workspaceID, variableName string, mutations map[string]bool, action string, hostlists []string) taskResult {
+	for attempt := 0; attempt < varUpdateRetries; attempt++ {
+		tfe_pool, pool_set, err := readPoolVariable(ctx, client, workspaceID, variableName)
+		if err != nil {
+			return taskResult{err: err}
+		}
+
+		applyMutations(pool_set, mutations)
+
+		value, err := marshalPool(pool_set)
+		if err != nil {
+			return taskResult{err: err}
+		}
+
+		updated, err := client.Variables.Update(ctx, workspaceID, tfe_pool.ID, tfe.VariableUpdateOptions{Value: &value})
+		if err != nil {
+			if isStaleWriteError(err) {
+				log.Println("pool variable changed concurrently, retrying", workspaceID)
+				continue
+			}
+			return taskResult{err: err}
+		}
+
+		changed := tfe_pool.Value != updated.Value
+		if changed {
+			log.Println("Updating pool ", tfe_pool.Value, "->", updated.Value)
+		} else {
+			log.Println("no change")
+		}
+
+		if !changed || !runCfg.enabled {
+			return taskResult{}
+		}
+
+		targets := make([]string, 0, len(mutations))
+		for node := range mutations {
+			targets = append(targets, node)
+		}
+		run, err := triggerRun(ctx, client, runCfg, workspaceID, action, hostlists, targets)
+		if err != nil {
+			return taskResult{err: err}
+		}
+		return taskResult{runID: run.ID, runStatus: string(run.Status)}
+	}
+	return taskResult{err: fmt.Errorf("pool variable for workspace %s kept changing concurrently after %d attempts", workspaceID, varUpdateRetries)}
+}
+
+// updateWorkspacesPool applies the same mutations to the variableName
+// variable of several workspaces as one atomic operation: if any workspace fails to
+// update, every workspace already updated in this call is rolled back to
+// its original value.
+func updateWorkspacesPool(ctx context.Context, client *tfe.Client, runCfg *runConfig, workspaceIDs []string, variableName string, mutations map[string]bool, action string, hostlists []string) taskResult {
+	type applied struct {
+		workspaceID string
+		variableID  string
+		priorValue  string
+	}
+	var done []applied
+
+	rollback := func() {
+		for _, a := range done {
+			value := a.priorValue
+			if _, err := client.Variables.Update(ctx, a.workspaceID, a.variableID, tfe.VariableUpdateOptions{Value: &value}); err != nil {
+				log.Printf("rollback failed for workspace %s: %v (manual intervention required)", a.workspaceID, err)
+			}
+		}
+	}
+
+	for _, workspaceID := range workspaceIDs {
+		tfe_pool, pool_set, err := readPoolVariable(ctx, client, workspaceID, variableName)
+		if err != nil {
+			rollback()
+			return taskResult{err: fmt.Errorf("workspace %s: %w", workspaceID, err)}
+		}
+
+		applyMutations(pool_set, mutations)
+
+		value, err := marshalPool(pool_set)
+		if err != nil {
+			rollback()
+			return taskResult{err: err}
+		}
+
+		updated, err := client.Variables.Update(ctx, workspaceID, tfe_pool.ID, tfe.VariableUpdateOptions{Value: &value})
+		if err != nil {
+			rollback()
+			return taskResult{err: fmt.Errorf("workspace %s: %w", workspaceID, err)}
+		}
+		done = append(done, applied{workspaceID: workspaceID, variableID: tfe_pool.ID, priorValue: tfe_pool.Value})
+		log.Println("Updating pool for", workspaceID, tfe_pool.Value, "->", updated.Value)
+	}
+
+	if !runCfg.enabled {
+		return taskResult{}
+	}
+
+	targets := make([]string, 0, len(mutations))
+	for node := range mutations {
+		targets = append(targets, node)
+	}
+	var runIDs, statuses []string
+	for _, workspaceID := range workspaceIDs {
+		run, err := triggerRun(ctx, client, runCfg, workspaceID, action, hostlists, targets)
+		if err != nil {
+			return taskResult{err: fmt.Errorf("workspace %s: %w", workspaceID, err)}
+		}
+		runIDs = append(runIDs, run.ID)
+		statuses = append(statuses, string(run.Status))
+	}
+	return taskResult{runID: strings.Join(runIDs, ","), runStatus: strings.Join(statuses, ",")}
+}
+
+func readPoolVariable(ctx context.Context, client *tfe.Client, workspaceID, variableName string) (*tfe.Variable, map[string]bool, error) {
+	var_list, err := client.Variables.List(ctx, workspaceID, &tfe.VariableListOptions{})
+	if err != nil {
+		return nil, nil, err
+	}
+	var tfe_pool *tfe.Variable
+	for _, s := range var_list.Items {
+		if s.Key == variableName {
+			tfe_pool = s
+			break
+		}
+	}
+	if tfe_pool == nil {
+		return nil, nil, fmt.Errorf("%s variable not found in TFE workspace %s", variableName, workspaceID)
+	}
+
+	pool_set, err := unmarshalPool(tfe_pool.Value)
+	if err != nil {
+		return nil, nil, err
+	}
+	return tfe_pool, pool_set, nil
+}
+
+// isStaleWriteError reports whether err looks like TFE rejecting a write
+// because the variable was modified since we last read it (a 409 Conflict).
+func isStaleWriteError(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "409")
+}
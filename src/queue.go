@@ -0,0 +1,201 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// coalesceWindow is how long the daemon waits after the first request for a
+// target before it flushes the batch, so that a burst of ResumeProgram /
+// SuspendProgram invocations for the same hostlist collapse into a single
+// write.
+const coalesceWindow = 250 * time.Millisecond
+
+// task is one resume/suspend request waiting to be applied to a pool
+// variable. target identifies where that variable lives and is what the
+// taskPool locks and batches on: a variable set ID, or the comma-joined list
+// of workspace IDs when TFE_WORKSPACE_IDS fans one mutation out to several
+// workspaces.
+type task struct {
+	target       string
+	variableName string // defaults to "pool"; configurable per-pool via --config
+	varSetID     string
+	workspaceIDs []string
+	action       string // "resume" or "suspend"
+	nodes        []string
+	hostlist     string // the raw hostlist argument Slurm passed, e.g. "node[1-4]"
+	done         chan taskResult
+}
+
+// taskResult is what a flushed batch reports back to every task it contains.
+type taskResult struct {
+	err       error
+	runID     string
+	runStatus string
+}
+
+// batchContext is what a flushed batch hands to apply: the merged mutation
+// set plus enough of the originating tasks' metadata to write it to the
+// right place and synthesize a run message.
+type batchContext struct {
+	variableName string
+	varSetID     string
+	workspaceIDs []string
+	mutations    map[string]bool
+	action       string
+	hostlists    []string
+}
+
+// batch accumulates the tasks the daemon decided to coalesce for a single
+// target inside one coalesceWindow.
+type batch struct {
+	target string
+	tasks  []*task
+}
+
+// release is how runBatch hands a finished batch back to the serializer
+// goroutine so that activeNodes/targetState are only ever touched from that
+// one goroutine.
+type release struct {
+	batch  *batch
+	result taskResult
+}
+
+// targetState is what the serializer goroutine keeps per target: at most one
+// batch being coalesced (pending) and at most one batch being applied
+// (busy). A target never has two applies in flight at once — a new arrival
+// while busy just joins pending, which only starts its own coalesce timer
+// once the in-flight apply reports back via released.
+type targetState struct {
+	pending *batch
+	busy    bool
+	timer   *time.Timer
+}
+
+// taskPool serializes access to targets and nodes, modeled on the
+// resource-locker pattern from Semaphore's taskPool: a single goroutine
+// (run) owns targetStates and activeNodes and is the only thing allowed to
+// mutate them, so callers never see two in-flight updates touch the same
+// target or overlapping node names. The blocking write itself (run's apply
+// call, which may poll a TFE run for minutes) happens in its own goroutine
+// per batch so unrelated targets are never held up behind it.
+type taskPool struct {
+	incoming     chan *task
+	released     chan *release
+	targetStates map[string]*targetState
+	activeNodes  map[string]string // node name -> target currently holding it
+	apply        func(*batchContext) taskResult
+}
+
+func newTaskPool(apply func(*batchContext) taskResult) *taskPool {
+	return &taskPool{
+		incoming:     make(chan *task, 256),
+		released:     make(chan *release, 16),
+		targetStates: make(map[string]*targetState),
+		activeNodes:  make(map[string]string),
+		apply:        apply,
+	}
+}
+
+// submit enqueues a task and blocks until the daemon has applied it (or
+// failed to).
+func (p *taskPool) submit(t *task) taskResult {
+	t.done = make(chan taskResult, 1)
+	p.incoming <- t
+	return <-t.done
+}
+
+// run is the serializer goroutine. It must be started exactly once.
+func (p *taskPool) run() {
+	flush := make(chan string, 16)
+
+	for {
+		select {
+		case t := <-p.incoming:
+			conflict := ""
+			for _, n := range t.nodes {
+				if owner, held := p.activeNodes[n]; held && owner != t.target {
+					conflict = fmt.Sprintf("node %s is already queued against %s", n, owner)
+					break
+				}
+			}
+			if conflict != "" {
+				t.done <- taskResult{err: errors.New(conflict)}
+				continue
+			}
+
+			st, ok := p.targetStates[t.target]
+			if !ok {
+				st = &targetState{}
+				p.targetStates[t.target] = st
+			}
+			if st.pending == nil {
+				st.pending = &batch{target: t.target}
+			}
+			st.pending.tasks = append(st.pending.tasks, t)
+			for _, n := range t.nodes {
+				p.activeNodes[n] = t.target
+			}
+			if !st.busy && st.timer == nil {
+				target := t.target
+				st.timer = time.AfterFunc(coalesceWindow, func() {
+					flush <- target
+				})
+			}
+
+		case target := <-flush:
+			st := p.targetStates[target]
+			st.timer = nil
+			if st.busy || st.pending == nil {
+				continue
+			}
+			b := st.pending
+			st.pending = nil
+			st.busy = true
+			go p.runBatch(b)
+
+		case r := <-p.released:
+			st := p.targetStates[r.batch.target]
+			for _, t := range r.batch.tasks {
+				for _, n := range t.nodes {
+					if p.activeNodes[n] == r.batch.target {
+						delete(p.activeNodes, n)
+					}
+				}
+				t.done <- r.result
+			}
+			st.busy = false
+			if st.pending != nil {
+				target := r.batch.target
+				st.timer = time.AfterFunc(coalesceWindow, func() {
+					flush <- target
+				})
+			} else {
+				delete(p.targetStates, r.batch.target)
+			}
+		}
+	}
+}
+
+// runBatch merges every task queued for a target into a single set of node
+// mutations, applies it with one write (off the serializer goroutine, since
+// this can block on a TFE call or a run-completion poll), and hands the
+// result back to run via p.released so the node locks and busy flag are
+// only ever touched from the serializer goroutine.
+func (p *taskPool) runBatch(b *batch) {
+	ctx := &batchContext{mutations: make(map[string]bool)}
+	for _, t := range b.tasks {
+		ctx.variableName = t.variableName
+		ctx.varSetID = t.varSetID
+		ctx.workspaceIDs = t.workspaceIDs
+		ctx.action = t.action
+		ctx.hostlists = append(ctx.hostlists, t.hostlist)
+		for _, n := range t.nodes {
+			ctx.mutations[n] = t.action == "resume"
+		}
+	}
+
+	result := p.apply(ctx)
+	p.released <- &release{batch: b, result: result}
+}
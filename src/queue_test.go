@@ -0,0 +1,58 @@
+package main
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestTaskPoolSerializesSameTarget reproduces the race where a second task
+// for a target already being applied started a second, concurrent apply
+// instead of joining the next batch for that target. Two tasks for the same
+// target are submitted straddling the coalesce window, against a fake apply
+// slow enough to still be running when the second arrives; inFlight must
+// never exceed 1.
+func TestTaskPoolSerializesSameTarget(t *testing.T) {
+	var inFlight int32
+	var maxInFlight int32
+
+	pool := newTaskPool(func(ctx *batchContext) taskResult {
+		n := atomic.AddInt32(&inFlight, 1)
+		for {
+			cur := atomic.LoadInt32(&maxInFlight)
+			if n <= cur || atomic.CompareAndSwapInt32(&maxInFlight, cur, n) {
+				break
+			}
+		}
+		time.Sleep(300 * time.Millisecond)
+		atomic.AddInt32(&inFlight, -1)
+		return taskResult{}
+	})
+	go pool.run()
+
+	results := make(chan taskResult, 2)
+	go func() {
+		results <- pool.submit(&task{target: "ws-1", action: "resume", nodes: []string{"node1"}})
+	}()
+
+	time.Sleep(coalesceWindow + 50*time.Millisecond)
+
+	go func() {
+		results <- pool.submit(&task{target: "ws-1", action: "resume", nodes: []string{"node2"}})
+	}()
+
+	for i := 0; i < 2; i++ {
+		select {
+		case r := <-results:
+			if r.err != nil {
+				t.Fatalf("unexpected task error: %v", r.err)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("timed out waiting for task result")
+		}
+	}
+
+	if max := atomic.LoadInt32(&maxInFlight); max > 1 {
+		t.Fatalf("expected at most 1 concurrent apply for the same target, got %d", max)
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	tfe "github.com/hashicorp/go-tfe"
+)
+
+// runConfig controls whether updateWorkspacePool should kick off a TFE run
+// after a pool variable change, and how it waits for that run to finish.
+type runConfig struct {
+	enabled          bool
+	autoApply        bool
+	pollInterval     time.Duration
+	targetAddrFormat string
+}
+
+// parseRunConfig reads -run/-auto-apply/-poll-interval/-target-addr-format
+// from the daemon's flag set, taking their defaults from
+// TFE_AUTO_RUN/TFE_AUTO_APPLY/TFE_RUN_POLL_INTERVAL/TFE_RUN_TARGET_ADDR_FORMAT,
+// so a flag given on the command line overrides its environment variable.
+func parseRunConfig(fs *flag.FlagSet) *runConfig {
+	cfg := &runConfig{}
+	fs.BoolVar(&cfg.enabled, "run", envBool("TFE_AUTO_RUN"), "queue a TFE run after the pool variable changes and wait for it to finish")
+	fs.BoolVar(&cfg.autoApply, "auto-apply", envBool("TFE_AUTO_APPLY"), "auto-apply the run created by -run instead of waiting for a confirmation")
+	fs.DurationVar(&cfg.pollInterval, "poll-interval", envDuration("TFE_RUN_POLL_INTERVAL", 10*time.Second), "how often to poll Runs.Read while waiting for the run to finish")
+	fs.StringVar(&cfg.targetAddrFormat, "target-addr-format", os.Getenv("TFE_RUN_TARGET_ADDR_FORMAT"), `fmt.Sprintf template turning a Slurm node name into a Terraform resource address for -target, e.g. "module.compute[\"%s\"]"; leave unset to queue an untargeted run`)
+	return cfg
+}
+
+func envBool(name string) bool {
+	v, err := strconv.ParseBool(os.Getenv(name))
+	return err == nil && v
+}
+
+func envDuration(name string, fallback time.Duration) time.Duration {
+	if v := os.Getenv(name); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			return d
+		}
+	}
+	return fallback
+}
+
+// runMessage synthesizes the commit message TFE shows for the run, e.g.
+// "slurm resume node[1-4]".
+func runMessage(action string, hostlists []string) string {
+	return fmt.Sprintf("slurm %s %s", action, strings.Join(hostlists, ","))
+}
+
+// targetAddrsForNodes turns the Slurm node names a batch touched into the
+// Terraform resource addresses -target expects, using cfg.targetAddrFormat
+// (e.g. "module.compute[\"%s\"]"). Bare hostnames aren't valid resource
+// addresses, so with no format configured it returns nil and the run isn't
+// targeted at all.
+func targetAddrsForNodes(cfg *runConfig, nodes []string) []string {
+	if cfg.targetAddrFormat == "" {
+		return nil
+	}
+	addrs := make([]string, len(nodes))
+	for i, n := range nodes {
+		addrs[i] = fmt.Sprintf(cfg.targetAddrFormat, n)
+	}
+	return addrs
+}
+
+// triggerRun queues a run for the nodes the batch resumed or suspended and
+// blocks until the run reaches a terminal status; it always waits, there is
+// no option to return as soon as the run is queued. nodes is
+// translated into -target resource addresses via cfg.targetAddrFormat so an
+// unrelated failure elsewhere in the workspace doesn't tear down nodes this
+// invocation never touched; with no format configured the run is untargeted.
+func triggerRun(ctx context.Context, client *tfe.Client, cfg *runConfig, workspaceID, action string, hostlists, nodes []string) (*tfe.Run, error) {
+	run, err := client.Runs.Create(ctx, tfe.RunCreateOptions{
+		Workspace:   &tfe.Workspace{ID: workspaceID},
+		Message:     tfe.String(runMessage(action, hostlists)),
+		AutoApply:   tfe.Bool(cfg.autoApply),
+		TargetAddrs: targetAddrsForNodes(cfg, nodes),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("creating run for workspace %s: %w", workspaceID, err)
+	}
+
+	log.Printf("queued run %s for workspace %s: %s", run.ID, workspaceID, runMessage(action, hostlists))
+	if err := waitForRun(ctx, client, cfg, run); err != nil {
+		return run, err
+	}
+
+	final, err := client.Runs.Read(ctx, run.ID)
+	if err != nil {
+		return run, err
+	}
+	return final, nil
+}
+
+// waitForRun polls Runs.Read until the run is "applied" or
+// "planned_and_finished" (returning nil) or "errored", "canceled" or
+// "discarded" (returning an error). Any other status, including a plan
+// waiting for confirmation, keeps it polling.
+func waitForRun(ctx context.Context, client *tfe.Client, cfg *runConfig, run *tfe.Run) error {
+	for {
+		r, err := client.Runs.Read(ctx, run.ID)
+		if err != nil {
+			return fmt.Errorf("reading run %s: %w", run.ID, err)
+		}
+
+		switch r.Status {
+		case tfe.RunApplied, tfe.RunPlannedAndFinished:
+			return nil
+		case tfe.RunErrored, tfe.RunCanceled, tfe.RunDiscarded:
+			return fmt.Errorf("run %s finished with status %s", r.ID, r.Status)
+		}
+
+		time.Sleep(cfg.pollInterval)
+	}
+}
@@ -1,88 +1,48 @@
 package main
 
 import (
-	"context"
-	"encoding/json"
-	"fmt"
+	"flag"
 	"log"
 	"os"
-
-	hostlist "github.com/abrekhov/hostlist"
-	tfe "github.com/hashicorp/go-tfe"
 )
 
+// slurm-autoscale-tfe has three modes, run from the same binary:
+//
+//	slurm-autoscale-tfe daemon            runs slurm-autoscale-tfed, the
+//	                                       long-lived process that owns the
+//	                                       TFE client and serializes writes
+//	                                       to the pool variable(s).
+//	slurm-autoscale-tfe resume|suspend [-config path] <hostlist>
+//	                                       the short-lived client invoked by
+//	                                       Slurm's ResumeProgram/
+//	                                       SuspendProgram; forwards the
+//	                                       request to the daemon and waits
+//	                                       for it to be applied.
+//	slurm-autoscale-tfe list-pools [-config path]
+//	                                       prints current pool membership,
+//	                                       for operators debugging routing.
 func main() {
-	tfe_workspace_id := os.Getenv("TFE_WORKSPACE_ID")
-	if tfe_workspace_id == "" {
-		log.Fatal("TFE_WORKSPACE_ID environment variable not set")
-	}
-
-	client, err := tfe.NewClient(tfe.DefaultConfig())
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	ctx := context.Background()
-	workspace, err := client.Workspaces.ReadByID(ctx, tfe_workspace_id)
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	var_list, _ := client.Variables.List(ctx, workspace.ID, &tfe.VariableListOptions{})
-	var tfe_pool *tfe.Variable
-	for _, s := range var_list.Items {
-		if s.Key == "pool" {
-			tfe_pool = s
-			break
+	if len(os.Args) < 2 {
+		log.Fatal("usage: slurm-autoscale-tfe daemon | resume|suspend [-config path] <hostlist> | list-pools [-config path]")
+	}
+
+	switch os.Args[1] {
+	case "daemon":
+		runDaemon()
+	case "resume", "suspend":
+		fs := flag.NewFlagSet(os.Args[1], flag.ExitOnError)
+		configPath := fs.String("config", os.Getenv("SLURM_AUTOSCALE_TFE_CONFIG"), "path to the multi-pool config file (see list-pools)")
+		fs.Parse(os.Args[2:])
+		if fs.NArg() < 1 {
+			log.Fatalf("usage: slurm-autoscale-tfe %s [-config path] <hostlist>", os.Args[1])
 		}
-	}
-	if tfe_pool == nil {
-		msg := fmt.Sprintf("%s variable not found in TFE workspace %s", "pool", workspace.Name)
-		log.Fatal(msg)
-	}
-
-	var pool []string
-	if err = json.Unmarshal([]byte(tfe_pool.Value), &pool); err != nil {
-		log.Fatal(err)
-	}
-	var pool_set = make(map[string]bool)
-	for _, s := range pool {
-		pool_set[s] = true
-	}
-
-	// Translate hostlist to list of nodes
-	nodes := hostlist.ExpandNodeList(os.Args[2])
-	if os.Args[1] == "resume" {
-		for _, s := range nodes {
-			pool_set[s] = true
-		}
-	} else if os.Args[1] == "suspend" {
-		for _, s := range nodes {
-			delete(pool_set, s)
-		}
-	}
-
-	keys := make([]string, len(pool_set))
-	i := 0
-	for k := range pool_set {
-		keys[i] = k
-		i++
-	}
-
-	pool_json, err := json.Marshal(keys)
-	if err != nil {
-		log.Fatal(err)
-	}
-	value := string(pool_json)
-	tfe_pool2, err := client.Variables.Update(ctx, workspace.ID, tfe_pool.ID, tfe.VariableUpdateOptions{Value: &value})
-
-	if tfe_pool.Value != tfe_pool2.Value {
-		log.Println("Updating pool ", tfe_pool.Value, "->", tfe_pool2.Value)
-	} else {
-		log.Println("no change")
-	}
-
-	if err != nil {
-		log.Fatal(err)
+		runClient(os.Args[1], fs.Arg(0), *configPath)
+	case "list-pools":
+		fs := flag.NewFlagSet("list-pools", flag.ExitOnError)
+		configPath := fs.String("config", defaultConfigPath, "path to the multi-pool config file")
+		fs.Parse(os.Args[2:])
+		runListPools(*configPath)
+	default:
+		log.Fatalf("unknown command %q, expected daemon, resume, suspend or list-pools", os.Args[1])
 	}
 }
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+
+	tfe "github.com/hashicorp/go-tfe"
+)
+
+// updateVarSetPool applies mutations to the variableName variable ("pool"
+// unless --config names another) stored on a TFE
+// Variable Set rather than on a single workspace, for sites that share one
+// autoscale controller across several workspaces. It retries on a stale
+// write exactly like updateWorkspacePool.
+func updateVarSetPool(ctx context.Context, client *tfe.Client, runCfg *runConfig, varSetID, variableName string, mutations map[string]bool, action string, hostlists []string) taskResult {
+	for attempt := 0; attempt < varUpdateRetries; attempt++ {
+		current, pool_set, err := readVarSetPoolVariable(ctx, client, varSetID, variableName)
+		if err != nil {
+			return taskResult{err: err}
+		}
+
+		applyMutations(pool_set, mutations)
+
+		value, err := marshalPool(pool_set)
+		if err != nil {
+			return taskResult{err: err}
+		}
+
+		updated, err := client.VariableSetVariables.Update(ctx, varSetID, current.ID, &tfe.VariableSetVariableUpdateOptions{Value: &value})
+		if err != nil {
+			if isStaleWriteError(err) {
+				log.Println("pool variable changed concurrently, retrying", varSetID)
+				continue
+			}
+			return taskResult{err: err}
+		}
+
+		changed := current.Value != updated.Value
+		if changed {
+			log.Println("Updating pool ", current.Value, "->", updated.Value)
+		} else {
+			log.Println("no change")
+		}
+
+		if !changed || !runCfg.enabled {
+			return taskResult{}
+		}
+		return taskResult{err: fmt.Errorf("variable set %s was updated but no run was queued: runs are only triggered for pools stored in workspace variables", varSetID)}
+	}
+	return taskResult{err: fmt.Errorf("pool variable for variable set %s kept changing concurrently after %d attempts", varSetID, varUpdateRetries)}
+}
+
+func readVarSetPoolVariable(ctx context.Context, client *tfe.Client, varSetID, variableName string) (*tfe.VariableSetVariable, map[string]bool, error) {
+	var_list, err := client.VariableSetVariables.List(ctx, varSetID, &tfe.VariableSetVariableListOptions{})
+	if err != nil {
+		return nil, nil, err
+	}
+	var tfe_pool *tfe.VariableSetVariable
+	for _, s := range var_list.Items {
+		if s.Key == variableName {
+			tfe_pool = s
+			break
+		}
+	}
+	if tfe_pool == nil {
+		return nil, nil, fmt.Errorf("%s variable not found in TFE variable set %s", variableName, varSetID)
+	}
+
+	pool_set, err := unmarshalPool(tfe_pool.Value)
+	if err != nil {
+		return nil, nil, err
+	}
+	return tfe_pool, pool_set, nil
+}
+
+// resolveVarSetID turns TFE_VARSET_NAME into a variable set ID by listing
+// the organization's variable sets, since go-tfe has no read-by-name call.
+// TFE_VARSET_ID is used as-is when set.
+func resolveVarSetID(ctx context.Context, client *tfe.Client, organization, varSetID, varSetName string) (string, error) {
+	if varSetID != "" {
+		return varSetID, nil
+	}
+	if varSetName == "" {
+		return "", nil
+	}
+	if organization == "" {
+		return "", fmt.Errorf("TFE_ORGANIZATION must be set to resolve TFE_VARSET_NAME %q", varSetName)
+	}
+
+	list, err := client.VariableSets.List(ctx, organization, &tfe.VariableSetListOptions{})
+	if err != nil {
+		return "", fmt.Errorf("listing variable sets in %s: %w", organization, err)
+	}
+	for _, vs := range list.Items {
+		if vs.Name == varSetName {
+			return vs.ID, nil
+		}
+	}
+	return "", fmt.Errorf("variable set %q not found in organization %s", varSetName, organization)
+}
+
+// unmarshalPool decodes a pool variable's JSON array value into a set.
+func unmarshalPool(value string) (map[string]bool, error) {
+	var pool []string
+	if err := json.Unmarshal([]byte(value), &pool); err != nil {
+		return nil, err
+	}
+	pool_set := make(map[string]bool)
+	for _, s := range pool {
+		pool_set[s] = true
+	}
+	return pool_set, nil
+}
+
+// marshalPool encodes a pool set back into the JSON array TFE expects.
+func marshalPool(pool_set map[string]bool) (string, error) {
+	keys := make([]string, 0, len(pool_set))
+	for k := range pool_set {
+		keys = append(keys, k)
+	}
+	pool_json, err := json.Marshal(keys)
+	if err != nil {
+		return "", err
+	}
+	return string(pool_json), nil
+}
+
+// applyMutations adds or removes nodes from pool_set in place.
+func applyMutations(pool_set map[string]bool, mutations map[string]bool) {
+	for node, add := range mutations {
+		if add {
+			pool_set[node] = true
+		} else {
+			delete(pool_set, node)
+		}
+	}
+}
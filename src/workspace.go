@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+
+	tfe "github.com/hashicorp/go-tfe"
+)
+
+// resolveWorkspaceIDs figures out which workspace IDs a request targets.
+// explicit (TFE_WORKSPACE_ID / TFE_WORKSPACE_IDS) always wins, matching the
+// provider convention of keeping the opaque ID as an override. Otherwise it
+// resolves name within organization the way the TFE provider resolves
+// agent-pool imports (<ORG>/<NAME>): a plain name does a single
+// Workspaces.Read, while a glob (e.g. "compute-*") paginates Workspaces.List
+// and returns every match, fanning the pool update across all of them.
+func resolveWorkspaceIDs(ctx context.Context, client *tfe.Client, organization string, explicit []string, name string) ([]string, error) {
+	if len(explicit) > 0 {
+		return explicit, nil
+	}
+	if name == "" {
+		return nil, nil
+	}
+	if organization == "" {
+		return nil, fmt.Errorf("TFE_ORGANIZATION must be set to resolve TFE_WORKSPACE_NAME %q", name)
+	}
+
+	if !isGlob(name) {
+		ws, err := client.Workspaces.Read(ctx, organization, name)
+		if err != nil {
+			return nil, fmt.Errorf("reading workspace %s/%s: %w", organization, name, err)
+		}
+		return []string{ws.ID}, nil
+	}
+
+	var ids []string
+	page := 1
+	for {
+		list, err := client.Workspaces.List(ctx, organization, &tfe.WorkspaceListOptions{
+			ListOptions: tfe.ListOptions{PageNumber: page, PageSize: 100},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("listing workspaces in %s: %w", organization, err)
+		}
+		for _, ws := range list.Items {
+			if ok, _ := path.Match(name, ws.Name); ok {
+				ids = append(ids, ws.ID)
+			}
+		}
+		if list.Pagination == nil || list.Pagination.NextPage == 0 {
+			break
+		}
+		page = list.Pagination.NextPage
+	}
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("no workspace in organization %s matched %q", organization, name)
+	}
+	return ids, nil
+}
+
+func isGlob(name string) bool {
+	return strings.ContainsAny(name, "*?[")
+}
+
+// nonEmpty wraps a possibly-empty string as a single-element slice,
+// matching the []string shape resolveWorkspaceIDs' explicit parameter
+// expects.
+func nonEmpty(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return []string{s}
+}